@@ -0,0 +1,348 @@
+// Package accesslog provides access-log formatting and a rotating, non-blocking
+// writer used by the HTTP server's logging middleware.
+package accesslog
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Entry describes a single completed HTTP request, independent of the
+// format it will eventually be rendered in.
+type Entry struct {
+    Time       time.Time
+    RemoteHost string
+    Ident      string // RFC1413 identity, always "-" for this server
+    User       string // authenticated user, always "-" for this server
+    Method     string
+    Path       string
+    Proto      string
+    Status     int
+    Bytes      int64
+    Referer    string
+    UserAgent  string
+    Duration   time.Duration
+    Encoding   string // negotiated Content-Encoding, e.g. "gzip"; "" if none
+}
+
+// Formatter renders an Entry as a single log line, without a trailing
+// newline.
+type Formatter interface {
+    Format(e Entry) []byte
+}
+
+// ParseFormatter resolves the -log-format flag value ("text", "combined" or
+// "json") to a Formatter, defaulting to TextFormatter for unknown values.
+func ParseFormatter(name string) Formatter {
+    switch name {
+    case "combined":
+        return CombinedFormatter{}
+    case "json":
+        return JSONFormatter{}
+    default:
+        return TextFormatter{}
+    }
+}
+
+// TextFormatter renders the original Chinese human-readable line used by
+// this server before structured logging was introduced.
+type TextFormatter struct{}
+
+func (TextFormatter) Format(e Entry) []byte {
+    encoding := e.Encoding
+    if encoding == "" {
+        encoding = "identity"
+    }
+    return []byte(fmt.Sprintf("[%s] %s %s - 完成 [状态:%d] [字节:%d] [编码:%s] [耗时:%v] [客户端:%s] [用户代理:%s]",
+        e.Time.Format("2006-01-02 15:04:05"),
+        e.Method, e.Path, e.Status, e.Bytes, encoding, e.Duration, e.RemoteHost, e.UserAgent))
+}
+
+// CombinedFormatter renders the Apache/NGINX Combined Log Format:
+//
+//	%h %l %u %t "%r" %>s %b "%{Referer}" "%{User-Agent}" %Dms
+type CombinedFormatter struct{}
+
+func (CombinedFormatter) Format(e Entry) []byte {
+    host := e.RemoteHost
+    if host == "" {
+        host = "-"
+    }
+    ident := orDash(e.Ident)
+    user := orDash(e.User)
+    referer := orDash(e.Referer)
+    ua := orDash(e.UserAgent)
+
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "%s %s %s [%s] \"%s %s %s\" %d %d \"%s\" \"%s\" %dms",
+        host, ident, user,
+        e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+        e.Method, e.Path, e.Proto,
+        e.Status, e.Bytes,
+        referer, ua,
+        e.Duration.Milliseconds())
+    return buf.Bytes()
+}
+
+// JSONFormatter renders a single-line JSON record. It is a lightweight
+// stand-in used when the caller only wants the access log itself in JSON,
+// as opposed to the structured request logging handled by AccessLogger.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(e Entry) []byte {
+    return []byte(fmt.Sprintf(
+        `{"time":%q,"remote_host":%q,"method":%q,"path":%q,"proto":%q,"status":%d,"bytes":%d,"encoding":%q,"referer":%q,"user_agent":%q,"duration_ms":%d}`,
+        e.Time.Format(time.RFC3339), e.RemoteHost, e.Method, e.Path, e.Proto,
+        e.Status, e.Bytes, e.Encoding, e.Referer, e.UserAgent, e.Duration.Milliseconds()))
+}
+
+func orDash(s string) string {
+    if s == "" {
+        return "-"
+    }
+    return s
+}
+
+// Writer formats and writes Entry values to an underlying io.Writer without
+// blocking the request goroutine: Log enqueues onto a buffered channel and a
+// background goroutine drains it. If the channel is full the entry is
+// dropped rather than applying backpressure to request handling.
+type Writer struct {
+    formatter Formatter
+    dest      io.Writer
+    entries   chan Entry
+    raw       chan string
+    done      chan struct{}
+    dropped   atomic.Uint64
+}
+
+// NewWriter starts a Writer backed by dest, buffering up to queueSize
+// pending entries.
+func NewWriter(dest io.Writer, formatter Formatter, queueSize int) *Writer {
+    if queueSize <= 0 {
+        queueSize = 1024
+    }
+    w := &Writer{
+        formatter: formatter,
+        dest:      dest,
+        entries:   make(chan Entry, queueSize),
+        raw:       make(chan string, queueSize),
+        done:      make(chan struct{}),
+    }
+    go w.run()
+    return w
+}
+
+func (w *Writer) run() {
+    defer close(w.done)
+    for {
+        select {
+        case e, ok := <-w.entries:
+            if !ok {
+                w.entries = nil
+            } else {
+                line := w.formatter.Format(e)
+                line = append(line, '\n')
+                w.dest.Write(line)
+            }
+        case line, ok := <-w.raw:
+            if !ok {
+                w.raw = nil
+            } else {
+                w.dest.Write([]byte(line + "\n"))
+            }
+        }
+        if w.entries == nil && w.raw == nil {
+            return
+        }
+    }
+}
+
+// Log enqueues e for asynchronous formatting and writing. It never blocks
+// the caller for more than a channel send.
+func (w *Writer) Log(e Entry) {
+    select {
+    case w.entries <- e:
+    default:
+        w.dropped.Add(1)
+    }
+}
+
+// LogEvent writes a plain operational line (server start/stop, log reopen,
+// ...) into the access log stream, bypassing the per-request Formatter.
+func (w *Writer) LogEvent(msg string) {
+    line := fmt.Sprintf("[%s] %s", time.Now().Format("2006-01-02 15:04:05"), msg)
+    select {
+    case w.raw <- line:
+    default:
+        w.dropped.Add(1)
+    }
+}
+
+// Close stops accepting new entries and waits for the queue to drain.
+func (w *Writer) Close() error {
+    close(w.entries)
+    close(w.raw)
+    <-w.done
+    return nil
+}
+
+// RotatingLogWriter writes access log lines to access-YYYY-MM-DD.log under
+// Dir, rolling over at local-timezone midnight and gzip-compressing the
+// previous day's file in the background. Compressed access-*.log.gz files
+// whose modification time is older than Retain days are removed after each
+// rollover.
+type RotatingLogWriter struct {
+    Dir    string
+    Retain int
+
+    mu      sync.Mutex
+    file    *os.File
+    day     string
+}
+
+// NewRotatingLogWriter opens (or creates) today's log file under dir.
+func NewRotatingLogWriter(dir string, retain int) (*RotatingLogWriter, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, err
+    }
+    r := &RotatingLogWriter{Dir: dir, Retain: retain}
+    if err := r.rotateLocked(time.Now()); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+func (r *RotatingLogWriter) pathFor(t time.Time) string {
+    return filepath.Join(r.Dir, fmt.Sprintf("access-%s.log", t.Format("2006-01-02")))
+}
+
+// Write implements io.Writer, rolling the underlying file if the local date
+// has changed since the last write.
+func (r *RotatingLogWriter) Write(p []byte) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    now := time.Now()
+    if now.Format("2006-01-02") != r.day {
+        if err := r.rotateLocked(now); err != nil {
+            return 0, err
+        }
+    }
+    return r.file.Write(p)
+}
+
+// rotateLocked must be called with mu held. It opens the log file for day,
+// and if a previous day's file was open, schedules it for gzip compression
+// and retention cleanup.
+func (r *RotatingLogWriter) rotateLocked(now time.Time) error {
+    prev := r.file
+    prevDay := r.day
+
+    day := now.Format("2006-01-02")
+    f, err := os.OpenFile(r.pathFor(now), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    r.file = f
+    r.day = day
+
+    if prev != nil {
+        prevPath := filepath.Join(r.Dir, fmt.Sprintf("access-%s.log", prevDay))
+        go func() {
+            prev.Close()
+            gzipFile(prevPath)
+            r.cleanupOldFiles()
+        }()
+    }
+    return nil
+}
+
+// Reopen closes and reopens today's file in place, for SIGHUP-driven
+// logrotate compatibility.
+func (r *RotatingLogWriter) Reopen() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.file != nil {
+        r.file.Close()
+    }
+    f, err := os.OpenFile(r.pathFor(time.Now()), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    r.file = f
+    return nil
+}
+
+func (r *RotatingLogWriter) Close() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.file == nil {
+        return nil
+    }
+    return r.file.Close()
+}
+
+// gzipFile compresses path to path+".gz" and removes the original on
+// success. Errors are silently ignored (the original is then kept); this is
+// best effort and must never crash the server.
+func gzipFile(path string) {
+    in, err := os.Open(path)
+    if err != nil {
+        return
+    }
+    defer in.Close()
+
+    out, err := os.Create(path + ".gz")
+    if err != nil {
+        return
+    }
+    defer out.Close()
+
+    gw := gzip.NewWriter(out)
+    if _, err := io.Copy(gw, in); err != nil {
+        gw.Close()
+        return
+    }
+    if err := gw.Close(); err != nil {
+        return
+    }
+    os.Remove(path)
+}
+
+// cleanupOldFiles removes access-*.log.gz files older than Retain days. It
+// is best effort: a failure to stat or remove one file does not stop the
+// scan.
+func (r *RotatingLogWriter) cleanupOldFiles() {
+    if r.Retain <= 0 {
+        return
+    }
+    cutoff := time.Now().AddDate(0, 0, -r.Retain)
+
+    entries, err := os.ReadDir(r.Dir)
+    if err != nil {
+        return
+    }
+    for _, ent := range entries {
+        name := ent.Name()
+        if ent.IsDir() || !strings.HasPrefix(name, "access-") || !strings.HasSuffix(name, ".log.gz") {
+            continue
+        }
+        info, err := ent.Info()
+        if err != nil {
+            continue
+        }
+        if info.ModTime().Before(cutoff) {
+            os.Remove(filepath.Join(r.Dir, name))
+        }
+    }
+}
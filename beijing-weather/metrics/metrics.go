@@ -0,0 +1,356 @@
+// Package metrics is a minimal, dependency-free Prometheus-compatible
+// metrics registry: counters and histograms backed by sync/atomic, with
+// label values sharded across a small number of locked maps ("lock-striped")
+// so that registering a brand-new label combination only briefly locks one
+// shard instead of the whole registry. It does not pull in the official
+// Prometheus client library.
+package metrics
+
+import (
+    "fmt"
+    "hash/fnv"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+const shardCount = 32
+
+// DefaultBuckets matches the Prometheus client's default histogram buckets,
+// in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds every metric exposed at /metrics. The zero value is not
+// usable; use NewRegistry.
+type Registry struct {
+    mu    sync.Mutex
+    names map[string]bool // guards against duplicate Register* calls
+
+    counterVecs   []*CounterVec
+    histogramVecs []*HistogramVec
+    gauges        []*Gauge
+}
+
+// NewRegistry builds an empty Registry.
+func NewRegistry() *Registry {
+    return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) claim(name string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.names[name] {
+        panic("metrics: duplicate metric name " + name)
+    }
+    r.names[name] = true
+}
+
+// NewCounterVec registers and returns a new CounterVec.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+    r.claim(name)
+    cv := newCounterVec(name, help, labelNames)
+    r.mu.Lock()
+    r.counterVecs = append(r.counterVecs, cv)
+    r.mu.Unlock()
+    return cv
+}
+
+// NewHistogramVec registers and returns a new HistogramVec with buckets.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+    r.claim(name)
+    hv := newHistogramVec(name, help, buckets, labelNames)
+    r.mu.Lock()
+    r.histogramVecs = append(r.histogramVecs, hv)
+    r.mu.Unlock()
+    return hv
+}
+
+// NewGauge registers and returns a new label-less Gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+    r.claim(name)
+    g := &Gauge{name: name, help: help}
+    r.mu.Lock()
+    r.gauges = append(r.gauges, g)
+    r.mu.Unlock()
+    return g
+}
+
+// writeExposition renders every registered metric in Prometheus text
+// exposition format. Named to avoid accidentally satisfying io.WriterTo,
+// whose (int64, error) return this doesn't need.
+func (r *Registry) writeExposition(w io.Writer) error {
+    var buf strings.Builder
+
+    r.mu.Lock()
+    gauges := append([]*Gauge(nil), r.gauges...)
+    counterVecs := append([]*CounterVec(nil), r.counterVecs...)
+    histogramVecs := append([]*HistogramVec(nil), r.histogramVecs...)
+    r.mu.Unlock()
+
+    for _, g := range gauges {
+        fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n",
+            g.name, g.help, g.name, g.name, formatFloat(g.Value()))
+    }
+    for _, cv := range counterVecs {
+        cv.writeTo(&buf)
+    }
+    for _, hv := range histogramVecs {
+        hv.writeTo(&buf)
+    }
+
+    _, err := io.WriteString(w, buf.String())
+    return err
+}
+
+// Handler returns an http.Handler suitable for mounting at /metrics.
+func (r *Registry) Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        r.writeExposition(w)
+    })
+}
+
+// Gauge is a single float64 value that can go up or down, stored as raw
+// IEEE-754 bits behind atomic.Uint64 so reads and writes never tear.
+type Gauge struct {
+    name, help string
+    bits       atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+func (g *Gauge) Add(delta float64) {
+    for {
+        old := g.bits.Load()
+        newV := math.Float64frombits(old) + delta
+        if g.bits.CompareAndSwap(old, math.Float64bits(newV)) {
+            return
+        }
+    }
+}
+
+func (g *Gauge) Inc() { g.Add(1) }
+func (g *Gauge) Dec() { g.Add(-1) }
+
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// CounterVec is a set of monotonically increasing counters keyed by a
+// label-value tuple (e.g. method, path, status).
+type CounterVec struct {
+    name, help string
+    labelNames []string
+    shards     [shardCount]*counterShard
+}
+
+type counterShard struct {
+    mu sync.RWMutex
+    m  map[string]*atomic.Uint64
+}
+
+func newCounterVec(name, help string, labelNames []string) *CounterVec {
+    cv := &CounterVec{name: name, help: help, labelNames: labelNames}
+    for i := range cv.shards {
+        cv.shards[i] = &counterShard{m: make(map[string]*atomic.Uint64)}
+    }
+    return cv
+}
+
+// Inc increments the counter for the given label values (in labelNames
+// order) by 1.
+func (cv *CounterVec) Inc(labelValues ...string) {
+    cv.counter(labelValues).Add(1)
+}
+
+// Add increments the counter for the given label values by delta.
+func (cv *CounterVec) Add(delta float64, labelValues ...string) {
+    // Counters are integral in this exposition format; callers wanting
+    // byte counts etc. should round at the call site.
+    cv.counter(labelValues).Add(uint64(delta))
+}
+
+func (cv *CounterVec) counter(labelValues []string) *atomic.Uint64 {
+    key := joinLabels(labelValues)
+    shard := cv.shards[shardFor(key)]
+
+    shard.mu.RLock()
+    c, ok := shard.m[key]
+    shard.mu.RUnlock()
+    if ok {
+        return c
+    }
+
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+    if c, ok := shard.m[key]; ok {
+        return c
+    }
+    c = &atomic.Uint64{}
+    shard.m[key] = c
+    return c
+}
+
+func (cv *CounterVec) writeTo(buf *strings.Builder) {
+    fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", cv.name, cv.help, cv.name)
+    for _, shard := range cv.shards {
+        shard.mu.RLock()
+        for key, c := range shard.m {
+            fmt.Fprintf(buf, "%s{%s} %d\n", cv.name, labelsString(cv.labelNames, key), c.Load())
+        }
+        shard.mu.RUnlock()
+    }
+}
+
+// Histogram is a single bucketed distribution, observed via atomic
+// increments so Observe never blocks on a mutex.
+type Histogram struct {
+    upperBounds []float64
+    counts      []atomic.Uint64 // counts[i] = observations in (upperBounds[i-1], upperBounds[i]]; last is +Inf; writeTo accumulates
+    sumBits     atomic.Uint64
+    total       atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+    bounds := append([]float64(nil), buckets...)
+    sort.Float64s(bounds)
+    h := &Histogram{
+        upperBounds: bounds,
+        counts:      make([]atomic.Uint64, len(bounds)+1), // +1 for the +Inf bucket
+    }
+    return h
+}
+
+// Observe records v (typically request duration in seconds).
+func (h *Histogram) Observe(v float64) {
+    idx := sort.SearchFloat64s(h.upperBounds, v)
+    h.counts[idx].Add(1)
+    h.total.Add(1)
+
+    for {
+        old := h.sumBits.Load()
+        newV := math.Float64frombits(old) + v
+        if h.sumBits.CompareAndSwap(old, math.Float64bits(newV)) {
+            break
+        }
+    }
+}
+
+// HistogramVec is a set of Histograms keyed by a label-value tuple.
+type HistogramVec struct {
+    name, help string
+    labelNames []string
+    buckets    []float64
+    shards     [shardCount]*histogramShard
+}
+
+type histogramShard struct {
+    mu sync.RWMutex
+    m  map[string]*Histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labelNames []string) *HistogramVec {
+    if len(buckets) == 0 {
+        buckets = DefaultBuckets
+    }
+    hv := &HistogramVec{name: name, help: help, buckets: buckets, labelNames: labelNames}
+    for i := range hv.shards {
+        hv.shards[i] = &histogramShard{m: make(map[string]*Histogram)}
+    }
+    return hv
+}
+
+// Observe records v for the given label values.
+func (hv *HistogramVec) Observe(v float64, labelValues ...string) {
+    hv.histogram(labelValues).Observe(v)
+}
+
+func (hv *HistogramVec) histogram(labelValues []string) *Histogram {
+    key := joinLabels(labelValues)
+    shard := hv.shards[shardFor(key)]
+
+    shard.mu.RLock()
+    h, ok := shard.m[key]
+    shard.mu.RUnlock()
+    if ok {
+        return h
+    }
+
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+    if h, ok := shard.m[key]; ok {
+        return h
+    }
+    h = newHistogram(hv.buckets)
+    shard.m[key] = h
+    return h
+}
+
+func (hv *HistogramVec) writeTo(buf *strings.Builder) {
+    fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s histogram\n", hv.name, hv.help, hv.name)
+    for _, shard := range hv.shards {
+        shard.mu.RLock()
+        for key, h := range shard.m {
+            labels := labelsString(hv.labelNames, key)
+            var cumulative uint64
+            for i, bound := range h.upperBounds {
+                cumulative += h.counts[i].Load()
+                fmt.Fprintf(buf, "%s_bucket{%sle=%q} %d\n", hv.name, labelPrefix(labels), formatFloat(bound), cumulative)
+            }
+            cumulative += h.counts[len(h.upperBounds)].Load()
+            fmt.Fprintf(buf, "%s_bucket{%sle=\"+Inf\"} %d\n", hv.name, labelPrefix(labels), cumulative)
+            fmt.Fprintf(buf, "%s_sum{%s} %s\n", hv.name, labels, formatFloat(math.Float64frombits(h.sumBits.Load())))
+            fmt.Fprintf(buf, "%s_count{%s} %d\n", hv.name, labels, h.total.Load())
+        }
+        shard.mu.RUnlock()
+    }
+}
+
+func labelPrefix(labels string) string {
+    if labels == "" {
+        return ""
+    }
+    return labels + ","
+}
+
+// joinLabels builds the shard/map key from label values. It intentionally
+// avoids escaping since it is only ever compared to itself, never rendered
+// directly.
+func joinLabels(values []string) string {
+    return strings.Join(values, "\x1f")
+}
+
+func labelsString(names []string, key string) string {
+    values := strings.Split(key, "\x1f")
+    parts := make([]string, 0, len(names))
+    for i, name := range names {
+        if i >= len(values) {
+            break
+        }
+        parts = append(parts, fmt.Sprintf("%s=%q", name, escapeLabelValue(values[i])))
+    }
+    return strings.Join(parts, ",")
+}
+
+func escapeLabelValue(v string) string {
+    v = strings.ReplaceAll(v, `\`, `\\`)
+    v = strings.ReplaceAll(v, `"`, `\"`)
+    v = strings.ReplaceAll(v, "\n", `\n`)
+    return v
+}
+
+func shardFor(key string) uint32 {
+    h := fnv.New32a()
+    h.Write([]byte(key))
+    return h.Sum32() % shardCount
+}
+
+func formatFloat(v float64) string {
+    if math.IsInf(v, 1) {
+        return "+Inf"
+    }
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
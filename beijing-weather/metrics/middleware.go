@@ -0,0 +1,127 @@
+package metrics
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// RouteTemplates bounds path label cardinality by collapsing concrete
+// request paths down to a small, fixed set of templates (e.g. every file
+// under /static/ becomes the single label "/static/*") before they reach a
+// CounterVec or HistogramVec keyed by path.
+type RouteTemplates struct {
+    prefixes map[string]string // registered prefix -> template, longest match wins
+}
+
+// NewRouteTemplates builds an empty registry. Use Register to add prefixes;
+// Resolve falls back to collapsing any path it doesn't recognize.
+func NewRouteTemplates() *RouteTemplates {
+    return &RouteTemplates{prefixes: make(map[string]string)}
+}
+
+// Register maps every path under prefix (a leading-slash, trailing-slash
+// directory such as "/static/") to template (such as "/static/*").
+func (rt *RouteTemplates) Register(prefix, template string) {
+    rt.prefixes[prefix] = template
+}
+
+// catchAll is the template any path falls back to once it matches neither
+// an exact registration nor a registered prefix, regardless of how many
+// segments it has. Without this, every distinct top-level 404 (arbitrary
+// typos, bots probing random paths, etc.) would mint its own label.
+const catchAll = "/*"
+
+// Resolve returns the template for path: an exact match if one was
+// registered, the longest registered prefix match, or catchAll for
+// anything else, regardless of how many segments the path has.
+// Unrecognized paths must be registered explicitly (via Register) to keep
+// their own label; every other path, including multi-segment ones like
+// "/wp-login/x", is bounded to catchAll rather than minting a per-segment
+// label.
+func (rt *RouteTemplates) Resolve(path string) string {
+    if tmpl, ok := rt.prefixes[path]; ok {
+        return tmpl
+    }
+
+    var bestPrefix, bestTemplate string
+    for prefix, tmpl := range rt.prefixes {
+        if strings.HasPrefix(path, prefix) && len(prefix) > len(bestPrefix) {
+            bestPrefix, bestTemplate = prefix, tmpl
+        }
+    }
+    if bestTemplate != "" {
+        return bestTemplate
+    }
+
+    return catchAll
+}
+
+// Middleware instruments requests_total, request_duration_seconds and
+// in-flight/bytes-served gauges, using templates to resolve a bounded path
+// label before recording anything.
+type Middleware struct {
+    requestsTotal   *CounterVec
+    requestDuration *HistogramVec
+    inFlight        *Gauge
+    bytesServed     *Gauge
+    templates       *RouteTemplates
+}
+
+// NewMiddleware registers the standard HTTP metrics on reg and returns a
+// Middleware that records them for every request it wraps.
+func NewMiddleware(reg *Registry, templates *RouteTemplates) *Middleware {
+    return &Middleware{
+        requestsTotal: reg.NewCounterVec("http_requests_total",
+            "Total number of HTTP requests.", "method", "path", "status"),
+        requestDuration: reg.NewHistogramVec("http_request_duration_seconds",
+            "HTTP request latency in seconds.", DefaultBuckets, "method", "path"),
+        inFlight: reg.NewGauge("http_requests_in_flight",
+            "Number of HTTP requests currently being served."),
+        bytesServed: reg.NewGauge("http_response_bytes_served",
+            "Cumulative number of response bytes served."),
+        templates: templates,
+    }
+}
+
+// Wrap instruments next.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        m.inFlight.Inc()
+        defer m.inFlight.Dec()
+
+        rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(rec, r)
+
+        path := m.templates.Resolve(r.URL.Path)
+        status := strconv.Itoa(rec.status)
+
+        m.requestsTotal.Inc(r.Method, path, status)
+        m.requestDuration.Observe(time.Since(start).Seconds(), r.Method, path)
+        m.bytesServed.Add(float64(rec.bytes))
+    })
+}
+
+type statusRecorder struct {
+    http.ResponseWriter
+    status      int
+    bytes       int64
+    wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+    s.status = code
+    s.wroteHeader = true
+    s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+    if !s.wroteHeader {
+        s.WriteHeader(http.StatusOK)
+    }
+    n, err := s.ResponseWriter.Write(p)
+    s.bytes += int64(n)
+    return n, err
+}
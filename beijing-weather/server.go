@@ -1,78 +1,198 @@
 package main
 
 import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "flag"
     "fmt"
+    "io"
     "log"
     "net/http"
     "os"
+    "os/signal"
+    "syscall"
     "time"
+
+    "beijing-weather/accesslog"
+    "beijing-weather/metrics"
+    "beijing-weather/requestlog"
+    "beijing-weather/staticfs"
+    "beijing-weather/weatherproxy"
 )
 
-// 日志中间件
-func loggingMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        start := time.Now()
-        
-        // 记录请求开始
-        log.Printf("[%s] %s %s - 开始处理", 
-            time.Now().Format("2006-01-02 15:04:05"),
-            r.Method, r.URL.Path)
-        
-        // 创建响应记录器
-        lrw := &loggingResponseWriter{
-            ResponseWriter: w,
-            statusCode:     200,
+var accessLog *accesslog.Writer
+
+// openLogOutput resolves the -log-output flag to a writer: "stdout" and
+// "stderr" map to the matching stream, anything else is treated as a file
+// path to append to. The returned close func is always safe to call.
+func openLogOutput(target string) (io.Writer, func(), error) {
+    switch target {
+    case "stdout", "":
+        return os.Stdout, func() {}, nil
+    case "stderr":
+        return os.Stderr, func() {}, nil
+    default:
+        f, err := os.OpenFile(target, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+        if err != nil {
+            return nil, func() {}, err
         }
-        
-        // 处理请求
-        next.ServeHTTP(lrw, r)
-        
-        // 记录请求完成
-        duration := time.Since(start)
-        log.Printf("[%s] %s %s - 完成 [状态:%d] [耗时:%v] [客户端:%s] [用户代理:%s]",
-            time.Now().Format("2006-01-02 15:04:05"),
-            r.Method, r.URL.Path, lrw.statusCode, duration,
-            r.RemoteAddr, r.UserAgent())
-    })
+        return f, func() { f.Close() }, nil
+    }
 }
 
-// 响应记录器
-type loggingResponseWriter struct {
-    http.ResponseWriter
-    statusCode int
-}
+func main() {
+    logFormat := flag.String("log-format", "text", "访问日志格式: text|combined|json")
+    logRetain := flag.Int("log-retain", 7, "访问日志保留天数")
+    shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "优雅关闭的最长等待时间")
+    tlsCert := flag.String("tls-cert", "", "TLS 证书文件路径，设置后以 HTTPS 方式监听")
+    tlsKey := flag.String("tls-key", "", "TLS 私钥文件路径，需与 -tls-cert 搭配使用")
+    logOutput := flag.String("log-output", "stdout", "结构化请求日志输出位置: stdout|stderr|文件路径")
+    cacheMaxAge := flag.Duration("cache-max-age", time.Hour, "静态文件 Cache-Control 的 max-age")
+    weatherUpstream := flag.String("weather-upstream", "https://api.openweathermap.org/data/2.5/weather", "天气上游 API 地址")
+    weatherKey := flag.String("weather-key", os.Getenv("WEATHER_API_KEY"), "天气 API 密钥，默认读取环境变量 WEATHER_API_KEY")
+    weatherTTL := flag.Duration("weather-ttl", 10*time.Minute, "天气响应缓存 TTL")
+    weatherStaleTTL := flag.Duration("weather-stale-ttl", 30*time.Minute, "熔断期间可继续提供的过期缓存时长")
+    weatherBreakerThreshold := flag.Int("weather-breaker-threshold", 3, "触发熔断的连续上游失败次数")
+    flag.Parse()
 
-func (lrw *loggingResponseWriter) WriteHeader(code int) {
-    lrw.statusCode = code
-    lrw.ResponseWriter.WriteHeader(code)
-}
+    args := flag.Args()
 
-func main() {
     // 设置日志格式
     log.SetFlags(log.LstdFlags)
-    
-    // 设置静态文件服务
-    fs := http.FileServer(http.Dir("."))
-    
-    // 添加日志中间件
-    http.Handle("/", loggingMiddleware(fs))
+
+    // 初始化访问日志：按天滚动写入 access-YYYY-MM-DD.log，旧文件异步 gzip 压缩
+    rotatingWriter, err := accesslog.NewRotatingLogWriter(".", *logRetain)
+    if err != nil {
+        log.Fatalf("无法初始化访问日志: %v", err)
+    }
+    defer rotatingWriter.Close()
+    accessLog = accesslog.NewWriter(rotatingWriter, accesslog.ParseFormatter(*logFormat), 1024)
+    defer accessLog.Close()
+
+    // 打开结构化请求日志输出目标
+    logOutputWriter, closeLogOutput, err := openLogOutput(*logOutput)
+    if err != nil {
+        log.Fatalf("无法打开 -log-output %q: %v", *logOutput, err)
+    }
+    defer closeLogOutput()
+    structuredLogger := requestlog.NewAccessLogger(logOutputWriter, accessLog)
+
+    // 设置静态文件服务，并叠加 ETag/条件请求/压缩协商
+    fs := staticfs.Wrap(http.FileServer(http.Dir(".")), staticfs.Options{
+        Root:        ".",
+        CacheMaxAge: *cacheMaxAge,
+    })
+
+    // 初始化 Prometheus 风格指标：/static/* 之类的路径收敛为模板，避免标签基数爆炸
+    metricsRegistry := metrics.NewRegistry()
+    routeTemplates := metrics.NewRouteTemplates()
+    routeTemplates.Register("/static/", "/static/*")
+    metricsMiddleware := metrics.NewMiddleware(metricsRegistry, routeTemplates)
+
+    // 添加日志中间件：structuredLogger 一次性产出结构化 JSON 记录（含 request id）
+    // 并驱动旧的 text/combined/json 访问日志，最外层由指标中间件记录请求量、延迟分布与并发数
+    mux := http.NewServeMux()
+    mux.Handle("/", metricsMiddleware.Wrap(structuredLogger.Middleware(fs)))
+    mux.Handle("/metrics", metricsRegistry.Handler())
+
+    // /api/weather：服务端代理天气上游，密钥不下发到浏览器，避免 CORS 与密钥泄露
+    if *weatherKey == "" {
+        log.Print("警告: 未设置 -weather-key / WEATHER_API_KEY，/api/weather 将无法通过上游鉴权")
+    }
+    weatherProxy, err := weatherproxy.New(weatherproxy.Options{
+        Upstream:         *weatherUpstream,
+        APIKey:           *weatherKey,
+        TTL:              *weatherTTL,
+        StaleTTL:         *weatherStaleTTL,
+        BreakerThreshold: *weatherBreakerThreshold,
+    })
+    if err != nil {
+        log.Fatalf("无法初始化天气代理: %v", err)
+    }
+    mux.Handle("/api/weather", metricsMiddleware.Wrap(structuredLogger.Middleware(weatherProxy)))
 
     // 获取端口，默认8000
     port := "8000"
-    if len(os.Args) > 1 {
-        port = os.Args[1]
+    if len(args) > 0 {
+        port = args[0]
+    }
+
+    server := &http.Server{
+        Addr:    ":" + port,
+        Handler: mux,
+    }
+    useTLS := *tlsCert != "" && *tlsKey != ""
+    if useTLS {
+        server.TLSConfig = &tls.Config{
+            MinVersion: tls.VersionTLS12,
+            NextProtos: []string{"h2", "http/1.1"},
+        }
     }
 
     fmt.Printf("🌤️  北京朝阳区天气显示页面\n")
     fmt.Printf("================================\n")
     fmt.Printf("服务器启动时间: %s\n", time.Now().Format("2006-01-02 15:04:05"))
     fmt.Printf("监听端口: %s\n", port)
-    fmt.Printf("访问地址: http://localhost:%s\n", port)
-    fmt.Printf("测试地址: http://localhost:%s/test.html\n", port)
+    scheme := "http"
+    if useTLS {
+        scheme = "https"
+    }
+    fmt.Printf("访问地址: %s://localhost:%s\n", scheme, port)
+    fmt.Printf("测试地址: %s://localhost:%s/test.html\n", scheme, port)
     fmt.Printf("================================\n")
     fmt.Printf("服务器正在运行中，按 Ctrl+C 停止...\n\n")
-    
-    // 启动服务器
-    log.Printf("[%s] 服务器开始监听端口 %s", time.Now().Format("2006-01-02 15:04:05"), port)
-    log.Fatal(http.ListenAndServe(":"+port, nil))
-}
\ No newline at end of file
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    // SIGHUP 触发访问日志重新打开，便于配合外部 logrotate 使用
+    sighup := make(chan os.Signal, 1)
+    signal.Notify(sighup, syscall.SIGHUP)
+    go func() {
+        for range sighup {
+            if err := rotatingWriter.Reopen(); err != nil {
+                log.Printf("访问日志重新打开失败: %v", err)
+                continue
+            }
+            msg := "收到 SIGHUP，访问日志文件已重新打开"
+            log.Print(msg)
+            accessLog.LogEvent(msg)
+        }
+    }()
+
+    serveErr := make(chan error, 1)
+    go func() {
+        log.Printf("[%s] 服务器开始监听端口 %s", time.Now().Format("2006-01-02 15:04:05"), port)
+        accessLog.LogEvent(fmt.Sprintf("服务器开始监听端口 %s", port))
+        if useTLS {
+            serveErr <- server.ListenAndServeTLS(*tlsCert, *tlsKey)
+        } else {
+            serveErr <- server.ListenAndServe()
+        }
+    }()
+
+    select {
+    case err := <-serveErr:
+        if err != nil && !errors.Is(err, http.ErrServerClosed) {
+            log.Fatalf("服务器异常退出: %v", err)
+        }
+    case <-ctx.Done():
+        stop()
+        msg := "收到停止信号，开始优雅关闭服务器"
+        log.Print(msg)
+        accessLog.LogEvent(msg)
+
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+        defer cancel()
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            log.Printf("优雅关闭超时，强制退出: %v", err)
+        } else {
+            log.Print("服务器已优雅关闭")
+            accessLog.LogEvent("服务器已优雅关闭")
+        }
+    }
+
+    signal.Stop(sighup)
+    close(sighup)
+}
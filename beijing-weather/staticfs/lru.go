@@ -0,0 +1,73 @@
+package staticfs
+
+import (
+    "container/list"
+    "sync"
+)
+
+// etagLRU is a fixed-capacity, least-recently-used cache mapping a
+// (path, mtime, size) cacheKey to its computed ETag, so the SHA-256 over a
+// file's contents is only paid once per unchanged version of that file.
+type etagLRU struct {
+    mu       sync.Mutex
+    capacity int
+    ll       *list.List
+    items    map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+    path  string
+    mtime int64
+    size  int64
+}
+
+type cacheEntry struct {
+    key  cacheKey
+    etag string
+}
+
+func newEtagLRU(capacity int) *etagLRU {
+    if capacity <= 0 {
+        capacity = 1024
+    }
+    return &etagLRU{
+        capacity: capacity,
+        ll:       list.New(),
+        items:    make(map[cacheKey]*list.Element),
+    }
+}
+
+func (c *etagLRU) get(key cacheKey) (string, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    el, ok := c.items[key]
+    if !ok {
+        return "", false
+    }
+    c.ll.MoveToFront(el)
+    return el.Value.(*cacheEntry).etag, true
+}
+
+func (c *etagLRU) put(key cacheKey, etag string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if el, ok := c.items[key]; ok {
+        el.Value.(*cacheEntry).etag = etag
+        c.ll.MoveToFront(el)
+        return
+    }
+
+    el := c.ll.PushFront(&cacheEntry{key: key, etag: etag})
+    c.items[key] = el
+
+    for c.ll.Len() > c.capacity {
+        oldest := c.ll.Back()
+        if oldest == nil {
+            break
+        }
+        c.ll.Remove(oldest)
+        delete(c.items, oldest.Value.(*cacheEntry).key)
+    }
+}
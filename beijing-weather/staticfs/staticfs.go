@@ -0,0 +1,273 @@
+// Package staticfs wraps an http.FileServer-backed handler with strong
+// ETags, conditional GET (304 Not Modified), gzip/br content negotiation,
+// and a configurable Cache-Control max-age.
+package staticfs
+
+import (
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "mime"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Options configures Wrap.
+type Options struct {
+    // Root is the directory http.Dir(Root) serves from; it must match the
+    // root passed to the wrapped http.FileServer.
+    Root string
+    // CacheMaxAge is the max-age sent in Cache-Control for any file this
+    // middleware successfully stats and fingerprints.
+    CacheMaxAge time.Duration
+    // CompressMinBytes is the minimum file size for on-the-fly gzip when
+    // no precompressed .gz/.br sibling exists. Files smaller than this are
+    // served as-is; compressing tiny files rarely pays for itself.
+    CompressMinBytes int64
+    // ETagCacheSize bounds the number of (path, mtime, size) -> etag
+    // entries kept in memory.
+    ETagCacheSize int
+}
+
+const defaultCompressMinBytes = 1024
+
+// Wrap returns next wrapped with ETag/conditional-GET/compression handling.
+// Requests it cannot fingerprint (directories, missing files, non-GET/HEAD
+// methods) fall straight through to next unchanged.
+func Wrap(next http.Handler, opts Options) http.Handler {
+    if opts.CompressMinBytes <= 0 {
+        opts.CompressMinBytes = defaultCompressMinBytes
+    }
+    cache := newEtagLRU(opts.ETagCacheSize)
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        diskPath := filepath.Join(opts.Root, filepath.Clean("/"+r.URL.Path))
+        info, err := os.Stat(diskPath)
+        if err != nil || info.IsDir() {
+            // Let the wrapped FileServer handle 404s, directory listings
+            // and index.html resolution on its own.
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        baseEtag, err := etagFor(cache, diskPath, info)
+        if err != nil {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        // The representation actually sent varies with the negotiated
+        // encoding, so the ETag must too: a strong ETag shared across
+        // identity/gzip/br would let a client holding the gzipped body
+        // send If-None-Match with Accept-Encoding: identity, get a 304,
+        // and reuse gzip bytes as identity.
+        pc := negotiatePrecompressed(r, diskPath)
+        if pc != nil {
+            defer pc.file.Close()
+        }
+        encoding := ""
+        switch {
+        case pc != nil:
+            encoding = pc.encoding
+        case info.Size() >= opts.CompressMinBytes && acceptsEncoding(r, "gzip"):
+            encoding = "gzip"
+        }
+        etag := etagForEncoding(baseEtag, encoding)
+
+        w.Header().Set("ETag", etag)
+        w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+        if opts.CacheMaxAge > 0 {
+            w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(opts.CacheMaxAge.Seconds())))
+        }
+        if encoding != "" {
+            w.Header().Set("Vary", "Accept-Encoding")
+        }
+
+        if notModified(r, etag, info.ModTime()) {
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+
+        if pc != nil {
+            servePrecompressed(w, r, diskPath, pc)
+            return
+        }
+
+        if encoding == "gzip" {
+            w.Header().Set("Content-Encoding", "gzip")
+            w.Header().Del("Content-Length")
+            // We're about to gzip whatever next writes, so a Range request
+            // against the underlying (uncompressed) file would make
+            // http.ServeContent serve a slice of the original bytes that we
+            // then gzip in isolation — a corrupt partial body that doesn't
+            // match the Content-Range we'd have claimed. Refuse ranges
+            // instead of serving garbage.
+            r.Header.Del("Range")
+            w.Header().Set("Accept-Ranges", "none")
+            gzw := gzip.NewWriter(w)
+            defer gzw.Close()
+            next.ServeHTTP(&gzipResponseWriter{ResponseWriter: w, gz: gzw}, r)
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// etagFor returns the cached ETag for diskPath if its mtime/size still
+// match, otherwise streams the file through SHA-256 and caches the result.
+// Streaming (rather than reading the whole file into memory first) keeps
+// memory use flat regardless of file size.
+func etagFor(cache *etagLRU, diskPath string, info os.FileInfo) (string, error) {
+    key := cacheKey{path: diskPath, mtime: info.ModTime().UnixNano(), size: info.Size()}
+    if etag, ok := cache.get(key); ok {
+        return etag, nil
+    }
+
+    f, err := os.Open(diskPath)
+    if err != nil {
+        return "", err
+    }
+    defer f.Close()
+
+    h := sha256.New()
+    if _, err := io.Copy(h, f); err != nil {
+        return "", err
+    }
+    etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+    cache.put(key, etag)
+    return etag, nil
+}
+
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+    if inm := r.Header.Get("If-None-Match"); inm != "" {
+        return inm == etag || inm == "*"
+    }
+    if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+        t, err := http.ParseTime(ims)
+        if err == nil && !modTime.Truncate(time.Second).After(t) {
+            return true
+        }
+    }
+    return false
+}
+
+// acceptsEncoding reports whether r's Accept-Encoding header lists enc
+// with a non-zero q value.
+func acceptsEncoding(r *http.Request, enc string) bool {
+    for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+        part = strings.TrimSpace(part)
+        name, q, _ := strings.Cut(part, ";")
+        if !strings.EqualFold(strings.TrimSpace(name), enc) {
+            continue
+        }
+        if v, ok := strings.CutPrefix(strings.ReplaceAll(q, " ", ""), "q="); ok {
+            if f, err := strconv.ParseFloat(v, 64); err == nil && f == 0 {
+                continue
+            }
+        }
+        return true
+    }
+    return false
+}
+
+// precompressedCandidates lists the sibling suffixes negotiatePrecompressed
+// looks for, in preference order.
+var precompressedCandidates = []struct {
+    suffix, encoding string
+}{
+    {".br", "br"},
+    {".gz", "gzip"},
+}
+
+// precompressedFile is a diskPath+".br"/".gz" sibling matching the client's
+// Accept-Encoding, opened up front so the encoding is known (and can be
+// folded into the ETag) before the 304 decision, without statting or
+// opening it twice.
+type precompressedFile struct {
+    file     *os.File
+    info     os.FileInfo
+    encoding string
+}
+
+// negotiatePrecompressed looks for a prebuilt diskPath+".br" or
+// diskPath+".gz" sibling matching the client's Accept-Encoding. The caller
+// must close the returned file, if any.
+func negotiatePrecompressed(r *http.Request, diskPath string) *precompressedFile {
+    for _, c := range precompressedCandidates {
+        if !acceptsEncoding(r, c.encoding) {
+            continue
+        }
+        compressed := diskPath + c.suffix
+        info, err := os.Stat(compressed)
+        if err != nil || info.IsDir() {
+            continue
+        }
+        f, err := os.Open(compressed)
+        if err != nil {
+            continue
+        }
+        return &precompressedFile{file: f, info: info, encoding: c.encoding}
+    }
+    return nil
+}
+
+// servePrecompressed streams pc, already located by negotiatePrecompressed,
+// with the original file's content type.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, diskPath string, pc *precompressedFile) {
+    w.Header().Set("Content-Encoding", pc.encoding)
+    w.Header().Del("Content-Length")
+    if ctype := mimeTypeFor(diskPath); ctype != "" {
+        w.Header().Set("Content-Type", ctype)
+    }
+    http.ServeContent(w, r, "", pc.info.ModTime(), pc.file)
+}
+
+// etagForEncoding folds encoding into a strong ETag (e.g. `"<sha>"` becomes
+// `"<sha>-gzip"`) so each negotiated representation of a file gets a
+// distinct validator; identity is left unchanged.
+func etagForEncoding(etag, encoding string) string {
+    if encoding == "" {
+        return etag
+    }
+    return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+func mimeTypeFor(path string) string {
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".html", ".htm":
+        return "text/html; charset=utf-8"
+    case ".css":
+        return "text/css; charset=utf-8"
+    case ".js":
+        return "application/javascript; charset=utf-8"
+    case ".json":
+        return "application/json; charset=utf-8"
+    case ".svg":
+        return "image/svg+xml"
+    default:
+        return mime.TypeByExtension(filepath.Ext(path))
+    }
+}
+
+// gzipResponseWriter transparently gzip-compresses everything written
+// through it, so the wrapped http.FileServer doesn't need to know
+// compression is happening.
+type gzipResponseWriter struct {
+    http.ResponseWriter
+    gz *gzip.Writer
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+    return g.gz.Write(p)
+}
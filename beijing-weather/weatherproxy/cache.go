@@ -0,0 +1,89 @@
+package weatherproxy
+
+import (
+    "container/list"
+    "sync"
+    "time"
+)
+
+// defaultCacheCapacity bounds the number of distinct query strings kept in
+// the response cache; see Options.CacheCapacity.
+const defaultCacheCapacity = 1024
+
+// responseCache is a fixed-capacity, least-recently-used cache of upstream
+// responses keyed by query string, mirroring staticfs's etagLRU. Entries
+// past their stale expiry are swept out lazily on put so that a steady
+// stream of distinct query params (bounded by capacity) can never grow the
+// map without limit.
+type responseCache struct {
+    mu       sync.Mutex
+    capacity int
+    ll       *list.List
+    items    map[string]*list.Element
+}
+
+type cacheElem struct {
+    key   string
+    entry *cacheEntry
+}
+
+func newResponseCache(capacity int) *responseCache {
+    if capacity <= 0 {
+        capacity = defaultCacheCapacity
+    }
+    return &responseCache{
+        capacity: capacity,
+        ll:       list.New(),
+        items:    make(map[string]*list.Element),
+    }
+}
+
+func (c *responseCache) get(key string) (*cacheEntry, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    el, ok := c.items[key]
+    if !ok {
+        return nil, false
+    }
+    c.ll.MoveToFront(el)
+    return el.Value.(*cacheElem).entry, true
+}
+
+func (c *responseCache) put(key string, entry *cacheEntry) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if el, ok := c.items[key]; ok {
+        el.Value.(*cacheElem).entry = entry
+        c.ll.MoveToFront(el)
+    } else {
+        el := c.ll.PushFront(&cacheElem{key: key, entry: entry})
+        c.items[key] = el
+    }
+
+    c.evictExpiredLocked()
+    for c.ll.Len() > c.capacity {
+        oldest := c.ll.Back()
+        if oldest == nil {
+            break
+        }
+        c.ll.Remove(oldest)
+        delete(c.items, oldest.Value.(*cacheElem).key)
+    }
+}
+
+// evictExpiredLocked drops every entry whose stale expiry has already
+// passed; it must be called with mu held.
+func (c *responseCache) evictExpiredLocked() {
+    now := time.Now()
+    var next *list.Element
+    for el := c.ll.Back(); el != nil; el = next {
+        next = el.Prev()
+        ce := el.Value.(*cacheElem)
+        if now.After(ce.entry.staleExpiry) {
+            c.ll.Remove(el)
+            delete(c.items, ce.key)
+        }
+    }
+}
@@ -0,0 +1,46 @@
+package weatherproxy
+
+import "sync"
+
+// singleflightGroup collapses concurrent calls for the same key into one
+// execution of fn, mirroring the shape of golang.org/x/sync/singleflight's
+// Group without taking on the external dependency.
+type singleflightGroup struct {
+    mu    sync.Mutex
+    calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+    wg     sync.WaitGroup
+    val    interface{}
+    err    error
+}
+
+// Do executes fn for key, or waits for and returns the result of an
+// already-in-flight call for the same key. shared reports whether the
+// result was shared with another caller rather than freshly computed.
+func (g *singleflightGroup) Do(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+    g.mu.Lock()
+    if g.calls == nil {
+        g.calls = make(map[string]*inflightCall)
+    }
+    if c, ok := g.calls[key]; ok {
+        g.mu.Unlock()
+        c.wg.Wait()
+        return c.val, c.err, true
+    }
+
+    c := new(inflightCall)
+    c.wg.Add(1)
+    g.calls[key] = c
+    g.mu.Unlock()
+
+    c.val, c.err = fn()
+    c.wg.Done()
+
+    g.mu.Lock()
+    delete(g.calls, key)
+    g.mu.Unlock()
+
+    return c.val, c.err, false
+}
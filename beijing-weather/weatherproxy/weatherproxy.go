@@ -0,0 +1,214 @@
+// Package weatherproxy implements /api/weather: a server-side reverse proxy
+// to an upstream weather API that keeps the API key out of the browser,
+// caches successful responses in memory, collapses concurrent cache misses
+// for the same query into a single upstream call, and falls back to a
+// stale cached response if the upstream looks down.
+package weatherproxy
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "net/http/httputil"
+    "net/url"
+    "sync"
+    "time"
+)
+
+// Options configures a Proxy.
+type Options struct {
+    // Upstream is the weather API base URL, e.g. https://api.openweathermap.org/data/2.5/weather.
+    Upstream string
+    // APIKey is injected into every upstream request server-side.
+    APIKey string
+    // APIKeyParam is the upstream query parameter the key is sent under
+    // (OpenWeather uses "appid").
+    APIKeyParam string
+    // TTL is how long a successful response is served from cache before a
+    // fresh upstream fetch is attempted.
+    TTL time.Duration
+    // StaleTTL is how much longer a cached response may be served, marked
+    // with X-Cache: STALE, once the circuit breaker has opened.
+    StaleTTL time.Duration
+    // BreakerThreshold is the number of consecutive upstream failures that
+    // opens the circuit and switches to serving stale responses.
+    BreakerThreshold int
+    // CacheCapacity bounds the number of distinct query strings kept in the
+    // response cache; least-recently-used entries are evicted once it's
+    // exceeded. Defaults to defaultCacheCapacity.
+    CacheCapacity int
+}
+
+// Proxy serves /api/weather.
+type Proxy struct {
+    opts  Options
+    proxy *httputil.ReverseProxy
+    group singleflightGroup
+    cache *responseCache
+
+    mu               sync.Mutex
+    consecutiveFails int
+}
+
+type cacheEntry struct {
+    status      int
+    header      http.Header
+    body        []byte
+    cachedAt    time.Time
+    expiresAt   time.Time
+    staleExpiry time.Time
+}
+
+// New builds a Proxy from opts. The caller mounts it at /api/weather.
+func New(opts Options) (*Proxy, error) {
+    upstream, err := url.Parse(opts.Upstream)
+    if err != nil {
+        return nil, err
+    }
+    if opts.APIKeyParam == "" {
+        opts.APIKeyParam = "appid"
+    }
+    if opts.BreakerThreshold <= 0 {
+        opts.BreakerThreshold = 3
+    }
+
+    p := &Proxy{
+        opts:  opts,
+        cache: newResponseCache(opts.CacheCapacity),
+    }
+    p.proxy = &httputil.ReverseProxy{
+        Director: func(r *http.Request) {
+            r.URL.Scheme = upstream.Scheme
+            r.URL.Host = upstream.Host
+            r.URL.Path = upstream.Path
+
+            q := r.URL.Query()
+            q.Set(opts.APIKeyParam, opts.APIKey)
+            r.URL.RawQuery = q.Encode()
+            r.Host = upstream.Host
+        },
+    }
+    return p, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    key := r.URL.RawQuery
+
+    if entry := p.freshCacheEntry(key); entry != nil {
+        writeEntry(w, entry, "")
+        return
+    }
+
+    if p.breakerOpen() {
+        if entry := p.staleCacheEntry(key); entry != nil {
+            writeEntry(w, entry, "STALE")
+            return
+        }
+    }
+
+    result, err, _ := p.group.Do(key, func() (interface{}, error) {
+        return p.fetchUpstream(r)
+    })
+    if err != nil {
+        if p.breakerOpen() {
+            if entry := p.staleCacheEntry(key); entry != nil {
+                writeEntry(w, entry, "STALE")
+                return
+            }
+        }
+        http.Error(w, "上游天气服务不可用", http.StatusBadGateway)
+        return
+    }
+
+    entry := result.(*cacheEntry)
+    writeEntry(w, entry, "MISS")
+}
+
+// fetchUpstream runs the reverse proxy against a response recorder so the
+// body can be cached, and updates the circuit breaker's failure count. Only
+// a 2xx upstream response counts as success and is cached; 5xx trips the
+// breaker; any other status (4xx) is passed through to the caller as-is but
+// left out of both the cache and the breaker's failure count, since it's
+// neither a cacheable "successful JSON response" nor evidence the upstream
+// itself is down.
+func (p *Proxy) fetchUpstream(r *http.Request) (*cacheEntry, error) {
+    rec := httptest.NewRecorder()
+
+    upstreamReq := r.Clone(r.Context())
+    p.proxy.ServeHTTP(rec, upstreamReq)
+
+    if rec.Code >= http.StatusInternalServerError {
+        p.recordFailure()
+        return nil, errUpstreamUnavailable
+    }
+
+    entry := &cacheEntry{
+        status: rec.Code,
+        header: rec.Header().Clone(),
+        body:   rec.Body.Bytes(),
+    }
+
+    if rec.Code >= http.StatusOK && rec.Code < http.StatusMultipleChoices {
+        p.recordSuccess()
+        now := time.Now()
+        entry.cachedAt = now
+        entry.expiresAt = now.Add(p.opts.TTL)
+        entry.staleExpiry = now.Add(p.opts.TTL + p.opts.StaleTTL)
+        p.cache.put(r.URL.RawQuery, entry)
+    }
+
+    return entry, nil
+}
+
+func (p *Proxy) freshCacheEntry(key string) *cacheEntry {
+    entry, ok := p.cache.get(key)
+    if !ok || time.Now().After(entry.expiresAt) {
+        return nil
+    }
+    return entry
+}
+
+func (p *Proxy) staleCacheEntry(key string) *cacheEntry {
+    entry, ok := p.cache.get(key)
+    if !ok || time.Now().After(entry.staleExpiry) {
+        return nil
+    }
+    return entry
+}
+
+func (p *Proxy) recordFailure() {
+    p.mu.Lock()
+    p.consecutiveFails++
+    p.mu.Unlock()
+}
+
+func (p *Proxy) recordSuccess() {
+    p.mu.Lock()
+    p.consecutiveFails = 0
+    p.mu.Unlock()
+}
+
+func (p *Proxy) breakerOpen() bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.consecutiveFails >= p.opts.BreakerThreshold
+}
+
+func writeEntry(w http.ResponseWriter, entry *cacheEntry, cacheStatus string) {
+    for k, values := range entry.header {
+        for _, v := range values {
+            w.Header().Add(k, v)
+        }
+    }
+    if cacheStatus != "" {
+        w.Header().Set("X-Cache", cacheStatus)
+    }
+    w.WriteHeader(entry.status)
+    w.Write(entry.body)
+}
+
+var errUpstreamUnavailable = httpError("weatherproxy: upstream unavailable")
+
+type httpError string
+
+func (e httpError) Error() string { return string(e) }